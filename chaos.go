@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// simulateFailures gates whether any scenario's chaos: block is applied.
+// It's a separate switch from Scenario.Chaos so a chaos block left in a
+// YAML file doesn't silently corrupt a real run.
+var simulateFailures = false
+
+// ChaosConfig describes probabilistic fault injection for a scenario, used
+// to exercise retry/backoff/reporting against a known failure distribution
+// instead of an unstable upstream. Each *_rate is a probability in [0, 1];
+// zero disables that fault.
+type ChaosConfig struct {
+	ErrorRate float64 `yaml:"error_rate"`
+
+	TimeoutRate float64 `yaml:"timeout_rate"`
+
+	LatencyRate float64 `yaml:"latency_rate"`
+	Latency     string  `yaml:"latency"`
+
+	StatusRate float64 `yaml:"status_rate"`
+	StatusCode int     `yaml:"status_code"`
+}
+
+func (c *ChaosConfig) latency() time.Duration {
+	if c == nil || c.Latency == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.Latency)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// chaosRoundTripper wraps a real http.RoundTripper, injecting failures
+// before (and rewriting status codes after) delegating to it. Living at
+// the transport layer means injected faults flow through the same
+// retry/backoff and reporting paths as a genuinely flaky upstream would.
+type chaosRoundTripper struct {
+	next http.RoundTripper
+	cfg  *ChaosConfig
+}
+
+func (c *chaosRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.cfg.ErrorRate > 0 && rand.Float64() < c.cfg.ErrorRate {
+		return nil, xerrors.Errorf("chaos: injected transport error")
+	}
+
+	if c.cfg.TimeoutRate > 0 && rand.Float64() < c.cfg.TimeoutRate {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	if delay := c.cfg.latency(); c.cfg.LatencyRate > 0 && rand.Float64() < c.cfg.LatencyRate && delay > 0 {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cfg.StatusRate > 0 && c.cfg.StatusCode > 0 && rand.Float64() < c.cfg.StatusRate {
+		resp.StatusCode = c.cfg.StatusCode
+	}
+	return resp, nil
+}
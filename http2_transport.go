@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/xerrors"
+)
+
+// HTTP2Config exposes a handful of golang.org/x/net/http2.Transport knobs
+// per scenario. Fields left zero fall back to the library's defaults. See
+// applyHTTP2Config for which of these are actually enforced client-side;
+// a non-zero InitialWindowSize is rejected rather than silently ignored.
+type HTTP2Config struct {
+	MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams"`
+	InitialWindowSize    uint32 `yaml:"initial_window_size"`
+	PingInterval         int    `yaml:"ping_interval"`
+}
+
+// http2Holder is a small round-robin pool of HTTP/2 round trippers. Since a
+// single http2.Transport multiplexes every request over one connection per
+// host, Connections lets a scenario fan requests out across several
+// connections instead, mirroring the worker-per-connection behaviour of the
+// http1 path.
+type http2Holder struct {
+	transports []http.RoundTripper
+	idx        uint64
+}
+
+func newHTTP2Holder(s Scenario, meter *bandwidthMeter) (*http2Holder, error) {
+	n := 1
+	if s.Connections != nil && *s.Connections > 0 {
+		n = *s.Connections
+	}
+
+	transports := make([]http.RoundTripper, n)
+	for i := range transports {
+		rt, err := newHTTP2RoundTripper(s, meter)
+		if err != nil {
+			return nil, err
+		}
+		transports[i] = rt
+	}
+	return &http2Holder{transports: transports}, nil
+}
+
+func (h *http2Holder) next() http.RoundTripper {
+	i := atomic.AddUint64(&h.idx, 1)
+	return h.transports[i%uint64(len(h.transports))]
+}
+
+func newHTTP2RoundTripper(s Scenario, meter *bandwidthMeter) (http.RoundTripper, error) {
+	if s.Protocol == "h2c" {
+		return newH2CTransport(s.HTTP2, meter)
+	}
+
+	// http2 and auto both negotiate via TLS ALPN, falling back to HTTP/1.1
+	// automatically when the server doesn't support HTTP/2.
+	base := &http.Transport{
+		DialContext:     meter.wrapDialContext((&net.Dialer{}).DialContext),
+		TLSClientConfig: &tls.Config{},
+	}
+	t2, err := http2.ConfigureTransports(base)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyHTTP2Config(t2, s.HTTP2); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+func newH2CTransport(cfg *HTTP2Config, meter *bandwidthMeter) (*http2.Transport, error) {
+	t2 := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return meter.wrapDialContext(func(_ context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial(network, addr)
+			})(context.Background(), network, addr)
+		},
+	}
+	if err := applyHTTP2Config(t2, cfg); err != nil {
+		return nil, err
+	}
+	return t2, nil
+}
+
+// applyHTTP2Config wires the subset of HTTP2Config that golang.org/x/net/http2.Transport
+// actually exposes on the client side, and rejects the rest rather than
+// letting them read as tuning that silently does nothing.
+func applyHTTP2Config(t2 *http2.Transport, cfg *HTTP2Config) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.PingInterval > 0 {
+		t2.ReadIdleTimeout = time.Duration(cfg.PingInterval) * time.Second
+		t2.PingTimeout = 10 * time.Second
+	}
+	if cfg.MaxConcurrentStreams > 0 {
+		// The client can't dictate the server's stream limit; the configured
+		// value itself isn't enforced, only StrictMaxConcurrentStreams is
+		// enabled, which refuses to exceed whatever the server advertises.
+		log.Printf("http2: max_concurrent_streams=%d is not enforced client-side, only StrictMaxConcurrentStreams is enabled", cfg.MaxConcurrentStreams)
+		t2.StrictMaxConcurrentStreams = true
+	}
+	if cfg.InitialWindowSize > 0 {
+		// http2.Transport has no client-side knob for the initial
+		// flow-control window, unlike the server-side config of the same
+		// name; reject it instead of silently ignoring it.
+		return xerrors.Errorf("http2: initial_window_size is not supported by the client transport")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// httpRequester is the default Requester backend, built on net/http and
+// transportHolder's keepalive/HTTP2 handling.
+type httpRequester struct {
+	th *transportHolder
+}
+
+func (r *httpRequester) Do(ctx context.Context, s Scenario) (Response, error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		log.Printf("[%s] Error: %s", s.Name, err)
+		return Response{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(httpTimeout)*time.Second)
+	defer cancel()
+
+	timing := newRequestTiming()
+	ctx = httptrace.WithClientTrace(ctx, timing.clientTrace())
+
+	req = req.WithContext(ctx)
+	rt, err := r.th.getRoundTripper(s)
+	if err != nil {
+		log.Printf("[%s] Error: %s", s.Name, err)
+		return Response{}, err
+	}
+	client := &http.Client{
+		Transport: rt,
+	}
+	resp, err := client.Do(req)
+	timing.done = time.Now()
+	latency := r.th.meter.record(timing)
+	if err != nil {
+		log.Printf("[%s] Error: %s", s.Name, err)
+		return Response{}, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return Response{StatusCode: resp.StatusCode, Latency: latency}, nil
+}
@@ -2,17 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -22,6 +25,8 @@ import (
 
 /*
 scenarios:
+  # Baseline scenario: net/http (the default engine), keepalive tuning, and
+  # retrying failed requests with exponential backoff.
   - name: ping
     url: https://google.com
     throughput: 1
@@ -31,11 +36,52 @@ scenarios:
     disable_keepalive: true
     keepalive: 10
     idle_timeout: 10
+    retry:
+      max: 3
+      initial_delay: 500ms
+      max_delay: 10s
+      multiplier: 2.0
+      jitter: 0.2
+      retry_on: [request_error, 5xx]
+
+  # HTTP/2 (or h2c for cleartext prior-knowledge) over a small pool of
+  # shared connections. protocol/connections/http2 are net_http-only; the
+  # fasthttp engine below ignores them.
+  - name: ping-http2
+    url: https://example.com
+    throughput: 10
+    protocol: http2
+    connections: 4
+    http2:
+      max_concurrent_streams: 100
+      ping_interval: 15
+
+  # High-throughput scenario on the fasthttp engine. Under
+  # --simulate-failures, fasthttp scenarios can't also set chaos: below.
+  - name: ping-fasthttp
+    url: https://example.com
+    throughput: 1000
+    engine: fasthttp
+
+  # Fault injection for self-testing retry/backoff/reporting; only applied
+  # when the binary is run with --simulate-failures, and only supported on
+  # the net_http engine.
+  - name: ping-chaos
+    url: https://example.com
+    throughput: 1
+    chaos:
+      error_rate: 0.05
+      timeout_rate: 0.02
+      latency_rate: 0.1
+      latency: 500ms
+      status_rate: 0.1
+      status_code: 502
 */
 
 var (
 	httpWorkerNum = 20
 	httpTimeout   = 10
+	statsInterval = 5
 
 	defaultDisableKeepalive = true
 	defaultKeepalive        = 10 * time.Second
@@ -60,9 +106,130 @@ type Scenario struct {
 	Keepalive        *int  `yaml:"keepalive"`
 	IdleTimeout      *int  `yaml:"idle_timeout"`
 
+	// Protocol selects the transport used for this scenario: "http1"
+	// (default), "http2" (TLS ALPN negotiation), "h2c" (cleartext HTTP/2
+	// with prior knowledge) or "auto" (negotiate, falling back to HTTP/1.1).
+	Protocol string `yaml:"protocol"`
+	// Connections caps the number of underlying HTTP/2 connections shared
+	// across this scenario's workers. Ignored outside http2/h2c/auto.
+	Connections *int         `yaml:"connections"`
+	HTTP2       *HTTP2Config `yaml:"http2"`
+
+	// Engine selects the request engine for this scenario ("net_http",
+	// the default, or "fasthttp"), overriding the global --engine flag.
+	Engine string `yaml:"engine"`
+
+	// Chaos is only applied when the --simulate-failures flag is set; it
+	// lets contributors validate retry/backoff/reporting against known
+	// failure distributions without needing an unstable upstream. Only the
+	// net_http engine can inject it; combining it with engine: fasthttp
+	// is rejected by newRequester rather than silently running clean.
+	Chaos *ChaosConfig `yaml:"chaos"`
+
+	Retry *RetryConfig `yaml:"retry"`
+
 	Validates []Validate `yaml:",flow"`
 }
 
+// RetryConfig controls how a scenario retries failed requests before they
+// are counted as a final fail. Delays are parsed with time.ParseDuration,
+// e.g. "500ms" or "10s".
+type RetryConfig struct {
+	Max          int      `yaml:"max"`
+	InitialDelay string   `yaml:"initial_delay"`
+	MaxDelay     string   `yaml:"max_delay"`
+	Multiplier   float64  `yaml:"multiplier"`
+	Jitter       float64  `yaml:"jitter"`
+	RetryOn      []string `yaml:"retry_on"`
+}
+
+var (
+	defaultRetryInitialDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay     = 10 * time.Second
+	defaultRetryMultiplier   = 2.0
+)
+
+func (r *RetryConfig) max() int {
+	if r == nil {
+		return 0
+	}
+	return r.Max
+}
+
+func (r *RetryConfig) initialDelay() time.Duration {
+	if r == nil || r.InitialDelay == "" {
+		return defaultRetryInitialDelay
+	}
+	d, err := time.ParseDuration(r.InitialDelay)
+	if err != nil {
+		return defaultRetryInitialDelay
+	}
+	return d
+}
+
+func (r *RetryConfig) maxDelay() time.Duration {
+	if r == nil || r.MaxDelay == "" {
+		return defaultRetryMaxDelay
+	}
+	d, err := time.ParseDuration(r.MaxDelay)
+	if err != nil {
+		return defaultRetryMaxDelay
+	}
+	return d
+}
+
+func (r *RetryConfig) multiplier() float64 {
+	if r == nil || r.Multiplier == 0 {
+		return defaultRetryMultiplier
+	}
+	return r.Multiplier
+}
+
+// backoffDelay computes the delay before the given retry attempt (0-indexed,
+// attempt 0 is the delay before the first retry), as
+// min(initial_delay * multiplier^attempt, max_delay) perturbed by ±jitter.
+func backoffDelay(r *RetryConfig, attempt int) time.Duration {
+	delay := float64(r.initialDelay()) * math.Pow(r.multiplier(), float64(attempt))
+	if max := float64(r.maxDelay()); delay > max {
+		delay = max
+	}
+
+	if r != nil && r.Jitter > 0 {
+		delta := delay * r.Jitter
+		delay += (rand.Float64()*2 - 1) * delta
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	return time.Duration(delay)
+}
+
+// shouldRetry reports whether a failed attempt matches one of the
+// retry_on reasons configured for the scenario.
+func shouldRetry(r *RetryConfig, state ResultState, statusCode int) bool {
+	if r == nil {
+		return false
+	}
+	for _, reason := range r.RetryOn {
+		switch reason {
+		case "request_error":
+			if state == ResultRequestFail {
+				return true
+			}
+		case "validation_fail":
+			if state == ResultValidationFail {
+				return true
+			}
+		case "5xx":
+			if statusCode >= 500 && statusCode < 600 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Validate is scenario validation structure
 type Validate struct {
 	Name string `yaml:"name"`
@@ -76,6 +243,7 @@ type ResultState int
 // Result enum
 const (
 	ResultOK ResultState = iota
+	ResultRetriedOK
 	ResultValidationFail
 	ResultRequestFail
 )
@@ -96,31 +264,7 @@ func LoadScenarioFile(in io.Reader) (*ScenarioData, error) {
 	return &s, nil
 }
 
-func runHttpRequest(ctx context.Context, s Scenario, th *transportHolder) (*http.Response, error) {
-	req, err := http.NewRequest("GET", s.URL, nil)
-	if err != nil {
-		log.Printf("[%s] Error: %s", s.Name, err)
-		return nil, err
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(httpTimeout)*time.Second)
-	defer cancel()
-
-	req = req.WithContext(ctx)
-	client := &http.Client{
-		Transport: th.getTransport(s.DisableKeepalive, s.Keepalive, s.IdleTimeout),
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("[%s] Error: %s", s.Name, err)
-		return nil, err
-	}
-	_, _ = io.Copy(ioutil.Discard, resp.Body)
-	_ = resp.Body.Close()
-	return resp, nil
-}
-
-func checkResponse(ctx context.Context, s Scenario, res *http.Response) error {
+func checkResponse(ctx context.Context, s Scenario, res Response) error {
 	for _, v := range s.Validates {
 		if v.StatusCode != nil && res.StatusCode != *v.StatusCode {
 			return xerrors.Errorf("%s: status code is invalid: expected: %v, got: %v", v.Name, *v.StatusCode, res.StatusCode)
@@ -129,27 +273,74 @@ func checkResponse(ctx context.Context, s Scenario, res *http.Response) error {
 	return nil
 }
 
-func syncWorker(ctx context.Context, s Scenario, th *transportHolder) ResultState {
-	res, err := runHttpRequest(ctx, s, th)
-	if err != nil {
-		return ResultRequestFail
-	}
-	if err := checkResponse(ctx, s, res); err != nil {
-		log.Printf("[%s] error: %v", s.Name, err)
-		return ResultValidationFail
-	}
-	log.Printf("[%s] Success", s.Name)
+// workResult is the outcome of a single syncWorker run, including how many
+// attempts (1 + retries) it took to reach that outcome.
+type workResult struct {
+	State    ResultState
+	Attempts int
+	Latency  time.Duration
+}
+
+func syncWorker(ctx context.Context, s Scenario, req Requester) workResult {
+	attempt := 0
+	for {
+		attempt++
 
-	return ResultOK
+		res, err := req.Do(ctx, s)
+
+		var (
+			state      ResultState
+			statusCode int
+		)
+		switch {
+		case err != nil:
+			state = ResultRequestFail
+		default:
+			statusCode = res.StatusCode
+			if err := checkResponse(ctx, s, res); err != nil {
+				log.Printf("[%s] error: %v", s.Name, err)
+				state = ResultValidationFail
+			}
+		}
+
+		// A 5xx response that otherwise passed validation (or has no
+		// validates at all) still counts as a failure when retry_on
+		// configures "5xx" on its own; treat it as ResultRequestFail before
+		// the success check below gets a chance to short-circuit on it.
+		if state == ResultOK && statusCode >= 500 && statusCode < 600 && shouldRetry(s.Retry, state, statusCode) {
+			state = ResultRequestFail
+		}
+
+		if state == ResultOK {
+			if attempt > 1 {
+				log.Printf("[%s] Success (attempt %d)", s.Name, attempt)
+				return workResult{State: ResultRetriedOK, Attempts: attempt, Latency: res.Latency}
+			}
+			log.Printf("[%s] Success", s.Name)
+			return workResult{State: ResultOK, Attempts: attempt, Latency: res.Latency}
+		}
+
+		if attempt > s.Retry.max() || !shouldRetry(s.Retry, state, statusCode) {
+			return workResult{State: state, Attempts: attempt, Latency: res.Latency}
+		}
+
+		delay := backoffDelay(s.Retry, attempt-1)
+		log.Printf("[%s] retrying attempt %d after %s", s.Name, attempt+1, delay)
+		select {
+		case <-ctx.Done():
+			return workResult{State: state, Attempts: attempt, Latency: res.Latency}
+		case <-time.After(delay):
+		}
+	}
 }
 
-func scenarioWorker(ctx context.Context, scenarioCh <-chan Scenario, th *transportHolder) <-chan ResultState {
-	reportCh := make(chan ResultState)
+func scenarioWorker(ctx context.Context, scenarioCh <-chan Scenario, req Requester) <-chan workResult {
+	reportCh := make(chan workResult)
 
 	go func() {
 		defer close(reportCh)
 		for s := range scenarioCh {
-			reportCh <- syncWorker(ctx, s, th)
+			reportCh <- syncWorker(ctx, s, req)
 		}
 	}()
 	return reportCh
@@ -158,13 +349,22 @@ func scenarioWorker(ctx context.Context, scenarioCh <-chan Scenario, th *transpo
 // ScenarioReport is aggregated scenario result
 type ScenarioReport struct {
 	SuccessCount        int
+	RetriedOKCount      int
 	ValidationFailCount int
 	RequestFailCount    int
+
+	// AttemptHistogram maps attempt count (1 = succeeded or failed on the
+	// first try) to the number of requests that finished after that many
+	// attempts, so flapping upstreams can be told apart from hard failures.
+	AttemptHistogram map[int]int
+
+	// Metrics holds bandwidth and latency figures for the run.
+	Metrics RateMetrics
 }
 
-func merge(channels ...<-chan ResultState) <-chan ResultState {
+func merge(channels ...<-chan workResult) <-chan workResult {
 	var wg sync.WaitGroup
-	ret := make(chan ResultState)
+	ret := make(chan workResult)
 
 	wg.Add(len(channels))
 	for _, c := range channels {
@@ -186,7 +386,9 @@ func merge(channels ...<-chan ResultState) <-chan ResultState {
 }
 
 // ScenarioRun runs scenario with context
-func ScenarioRun(ctx context.Context, s Scenario, th *transportHolder) ScenarioReport {
+func ScenarioRun(ctx context.Context, s Scenario, req Requester, meter *bandwidthMeter, reporter *Reporter) ScenarioReport {
+	start := time.Now()
+
 	rl := rate.NewLimiter(rate.Limit(s.Throughput), 1)
 
 	rlCh := make(chan struct{})
@@ -209,9 +411,9 @@ func ScenarioRun(ctx context.Context, s Scenario, th *transportHolder) ScenarioR
 	}
 
 	scenarioCh := make(chan Scenario)
-	chs := make([]<-chan ResultState, 0, httpWorkerNum)
+	chs := make([]<-chan workResult, 0, httpWorkerNum)
 	for i := 0; i < httpWorkerNum; i++ {
-		chs = append(chs, scenarioWorker(ctx, scenarioCh, th))
+		chs = append(chs, scenarioWorker(ctx, scenarioCh, req))
 	}
 
 	go func() {
@@ -232,22 +434,47 @@ func ScenarioRun(ctx context.Context, s Scenario, th *transportHolder) ScenarioR
 	}()
 
 	reportCh := merge(chs...)
-	var success, validationFail, requestFail int
-	for state := range reportCh {
-		switch state {
+	var success, retriedOK, validationFail, requestFail int
+	histogram := make(map[int]int)
+	for wr := range reportCh {
+		switch wr.State {
 		case ResultOK:
 			success++
+		case ResultRetriedOK:
+			retriedOK++
 		case ResultValidationFail:
 			validationFail++
 		case ResultRequestFail:
 			requestFail++
 		default:
 		}
+		histogram[wr.Attempts]++
+
+		// Bytes are read via the atomics directly rather than meter.snapshot(),
+		// which copies and sorts every latency sample collected so far; doing
+		// that per completed request would make this hot loop O(n² log n)
+		// over the run. The full snapshot is still taken once below for the
+		// end-of-run metrics.
+		reporter.Emit(ReportEvent{
+			Scenario:      s.Name,
+			State:         wr.State,
+			Attempt:       wr.Attempts,
+			Latency:       wr.Latency,
+			BytesSent:     atomic.LoadUint64(&meter.bytesSent),
+			BytesReceived: atomic.LoadUint64(&meter.bytesReceived),
+		})
 	}
+
+	metrics := meter.snapshot()
+	metrics.Elapsed = time.Since(start)
+
 	return ScenarioReport{
 		SuccessCount:        success,
+		RetriedOKCount:      retriedOK,
 		ValidationFailCount: validationFail,
 		RequestFailCount:    requestFail,
+		AttemptHistogram:    histogram,
+		Metrics:             metrics,
 	}
 }
 
@@ -255,6 +482,50 @@ type transportHolder struct {
 	transport       *http.Transport
 	sync            sync.Mutex
 	refreshDeadline time.Time
+
+	http2Once sync.Once
+	http2     *http2Holder
+	http2Err  error
+
+	meter *bandwidthMeter
+}
+
+// newTransportHolder builds a transportHolder that records connections and
+// request timings against meter.
+func newTransportHolder(meter *bandwidthMeter) *transportHolder {
+	return &transportHolder{meter: meter}
+}
+
+// getRoundTripper returns the transport to use for s, dispatching on
+// s.Protocol. The http1 transport (the default) keeps the existing
+// keepalive/refresh behaviour; http2/h2c/auto share a pool of HTTP/2
+// connections built once per transportHolder.
+func (th *transportHolder) getRoundTripper(s Scenario) (http.RoundTripper, error) {
+	rt, err := th.baseRoundTripper(s)
+	if err != nil {
+		return nil, err
+	}
+	if simulateFailures && s.Chaos != nil {
+		return &chaosRoundTripper{next: rt, cfg: s.Chaos}, nil
+	}
+	return rt, nil
+}
+
+func (th *transportHolder) baseRoundTripper(s Scenario) (http.RoundTripper, error) {
+	switch s.Protocol {
+	case "", "http1":
+		return th.getTransport(s.DisableKeepalive, s.Keepalive, s.IdleTimeout), nil
+	case "http2", "h2c", "auto":
+		th.http2Once.Do(func() {
+			th.http2, th.http2Err = newHTTP2Holder(s, th.meter)
+		})
+		if th.http2Err != nil {
+			return nil, th.http2Err
+		}
+		return th.http2.next(), nil
+	default:
+		return nil, xerrors.Errorf("unknown protocol %q", s.Protocol)
+	}
 }
 
 func (th *transportHolder) getTransport(disableKeepalive_ *bool, keepaliveTimeout_ *int, idleTimeout_ *int) *http.Transport {
@@ -278,11 +549,11 @@ func (th *transportHolder) getTransport(disableKeepalive_ *bool, keepaliveTimeou
 	if disableKeepalive {
 		if th.transport == nil {
 			th.transport = &http.Transport{
-				DialContext: (&net.Dialer{
+				DialContext: th.meter.wrapDialContext((&net.Dialer{
 					Timeout:   30 * time.Second,
 					KeepAlive: 30 * time.Second,
 					DualStack: true,
-				}).DialContext,
+				}).DialContext),
 				DisableKeepAlives:     disableKeepalive,
 				MaxIdleConns:          0,
 				MaxIdleConnsPerHost:   1000,
@@ -302,11 +573,11 @@ func (th *transportHolder) getTransport(disableKeepalive_ *bool, keepaliveTimeou
 
 		// https://golang.org/src/net/http/transport.go
 		th.transport = &http.Transport{
-			DialContext: (&net.Dialer{
+			DialContext: th.meter.wrapDialContext((&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
 				DualStack: true,
-			}).DialContext,
+			}).DialContext),
 			DisableKeepAlives:     disableKeepalive,
 			MaxIdleConns:          0,
 			MaxIdleConnsPerHost:   1000,
@@ -335,8 +606,21 @@ func (th *transportHolder) getTransport(disableKeepalive_ *bool, keepaliveTimeou
 func main() {
 	scenarioFileName := flag.String("f", "scenario.yml", "scenario file")
 	flag.IntVar(&httpWorkerNum, "c", 100, "http request concurrency per scenario")
+	flag.IntVar(&statsInterval, "stats-interval", 5, "seconds between in-flight stdout summaries, 0 to disable")
+	flag.StringVar(&engine, "engine", "net_http", "request engine: net_http or fasthttp")
+	flag.BoolVar(&simulateFailures, "simulate-failures", false, "inject failures described by each scenario's chaos: block")
+	reportFormat := flag.String("report-format", "text", "final summary format: text, json or ndjson")
+	reportFile := flag.String("report-file", "", "write every live ReportEvent as a JSON line to this file, replacing its contents")
+	metricsAddr := flag.String("metrics-addr", "", "serve live Prometheus metrics on this address, e.g. :9090")
+	statsdAddr := flag.String("statsd-addr", "", "send live counters to this StatsD/dogstatsd UDP address")
 	flag.Parse()
 
+	switch *reportFormat {
+	case "text", "json", "ndjson":
+	default:
+		log.Fatalf("unknown --report-format %q", *reportFormat)
+	}
+
 	f, err := os.Open(*scenarioFileName)
 	if err != nil {
 		log.Fatal(err)
@@ -364,6 +648,26 @@ func main() {
 		}
 	}()
 
+	sinks := []ReportSink{newStdoutSink(time.Duration(statsInterval) * time.Second)}
+	if *reportFile != "" {
+		sink, err := newJSONLSink(*reportFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if *metricsAddr != "" {
+		sinks = append(sinks, newPrometheusSink(*metricsAddr))
+	}
+	if *statsdAddr != "" {
+		sink, err := newStatsdSink(*statsdAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	reporter := NewReporter(sinks...)
+
 	wg := sync.WaitGroup{}
 	mutex := sync.Mutex{}
 	reports := make(map[string]ScenarioReport)
@@ -372,26 +676,63 @@ func main() {
 		wg.Add(1)
 		go func(s Scenario) {
 			defer wg.Done()
-			defer mutex.Unlock()
+
+			meter := newBandwidthMeter()
+			req, err := newRequester(s, meter)
+			if err != nil {
+				log.Printf("[%s] Error: %s", s.Name, err)
+				return
+			}
 
 			log.Printf("%s scenario start\n", s.Name)
-			report := ScenarioRun(ctx, s, &transportHolder{})
+			report := ScenarioRun(ctx, s, req, meter, reporter)
+
 			mutex.Lock()
 			reports[s.Name] = report
+			mutex.Unlock()
 		}(s)
 	}
 
 	log.Println("Running")
 	wg.Wait()
+	if err := reporter.Close(); err != nil {
+		log.Printf("Error closing report sinks: %s", err)
+	}
 	log.Println("--------------------Result--------------------")
 
-	for name, report := range reports {
-		var (
-			success        = report.SuccessCount
-			validationFail = report.ValidationFailCount
-			requestFail    = report.RequestFailCount
-		)
-		log.Printf("finished|[%s]\tsuccess: %d, validation fail: %d, request fail: %d",
-			name, success, validationFail, requestFail)
+	switch *reportFormat {
+	case "json":
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+	case "ndjson":
+		for name, report := range reports {
+			data, err := json.Marshal(struct {
+				Name string `json:"name"`
+				ScenarioReport
+			}{Name: name, ScenarioReport: report})
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(data))
+		}
+	default:
+		for name, report := range reports {
+			var (
+				success        = report.SuccessCount
+				retriedOK      = report.RetriedOKCount
+				validationFail = report.ValidationFailCount
+				requestFail    = report.RequestFailCount
+				m              = report.Metrics
+			)
+			log.Printf("finished|[%s]\tsuccess: %d, retried ok: %d, validation fail: %d, request fail: %d, attempts: %v, "+
+				"sent: %s, received: %s, req/s: %.1f, MB/s in: %.2f, MB/s out: %.2f, p50: %s, p90: %s, p99: %s, p999: %s",
+				name, success, retriedOK, validationFail, requestFail, report.AttemptHistogram,
+				formatBytes(m.BytesSent), formatBytes(m.BytesReceived),
+				m.RequestsPerSecond(), m.MBPerSecondIn(), m.MBPerSecondOut(),
+				m.P50, m.P90, m.P99, m.P999)
+		}
 	}
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connByteCounter wraps a net.Conn so every Read/Write atomically adds to
+// the byte counters of the bandwidthMeter that dialed it.
+type connByteCounter struct {
+	net.Conn
+	received *uint64
+	sent     *uint64
+}
+
+func (c *connByteCounter) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(c.received, uint64(n))
+	return n, err
+}
+
+func (c *connByteCounter) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(c.sent, uint64(n))
+	return n, err
+}
+
+// requestTiming captures the httptrace breakpoints for a single request so
+// DNS/connect/TLS/time-to-first-byte can be broken out from the overall
+// latency.
+type requestTiming struct {
+	start time.Time
+	done  time.Time
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+}
+
+func newRequestTiming() *requestTiming {
+	return &requestTiming{start: time.Now()}
+}
+
+func (t *requestTiming) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { t.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { t.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+}
+
+func elapsedSince(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// bandwidthMeter aggregates raw bytes sent/received across every connection
+// it dials, plus latency samples for every request recorded against it.
+type bandwidthMeter struct {
+	bytesSent     uint64
+	bytesReceived uint64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	dns       []time.Duration
+	connect   []time.Duration
+	tls       []time.Duration
+	ttfb      []time.Duration
+}
+
+func newBandwidthMeter() *bandwidthMeter {
+	return &bandwidthMeter{}
+}
+
+// wrapDialContext wraps a DialContext func so every byte read from or
+// written to the connections it dials is counted.
+func (m *bandwidthMeter) wrapDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &connByteCounter{Conn: conn, received: &m.bytesReceived, sent: &m.bytesSent}, nil
+	}
+}
+
+// record folds a completed request's timing into the meter's samples and
+// returns its overall latency, so callers can forward it to a Reporter.
+func (m *bandwidthMeter) record(t *requestTiming) time.Duration {
+	latency := elapsedSince(t.start, t.done)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.latencies = append(m.latencies, latency)
+	if d := elapsedSince(t.dnsStart, t.dnsDone); d > 0 {
+		m.dns = append(m.dns, d)
+	}
+	if d := elapsedSince(t.connectStart, t.connectDone); d > 0 {
+		m.connect = append(m.connect, d)
+	}
+	if d := elapsedSince(t.tlsStart, t.tlsDone); d > 0 {
+		m.tls = append(m.tls, d)
+	}
+	if d := elapsedSince(t.start, t.firstByte); d > 0 {
+		m.ttfb = append(m.ttfb, d)
+	}
+	return latency
+}
+
+// RateMetrics is a point-in-time snapshot of a bandwidthMeter, plus the
+// rates derived from it over Elapsed.
+type RateMetrics struct {
+	BytesSent     uint64
+	BytesReceived uint64
+	RequestCount  int
+	Elapsed       time.Duration
+
+	P50, P90, P99, P999 time.Duration
+
+	AvgDNS, AvgConnect, AvgTLS, AvgTTFB time.Duration
+}
+
+// RequestsPerSecond is the derived request rate over Elapsed.
+func (m RateMetrics) RequestsPerSecond() float64 {
+	if m.Elapsed <= 0 {
+		return 0
+	}
+	return float64(m.RequestCount) / m.Elapsed.Seconds()
+}
+
+// MBPerSecondIn is the derived inbound bandwidth over Elapsed, in MB/s.
+func (m RateMetrics) MBPerSecondIn() float64 {
+	return bytesPerSecondToMB(m.BytesReceived, m.Elapsed)
+}
+
+// MBPerSecondOut is the derived outbound bandwidth over Elapsed, in MB/s.
+func (m RateMetrics) MBPerSecondOut() float64 {
+	return bytesPerSecondToMB(m.BytesSent, m.Elapsed)
+}
+
+func bytesPerSecondToMB(bytes uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	const mb = 1024 * 1024
+	return float64(bytes) / mb / elapsed.Seconds()
+}
+
+// snapshot reports the current totals with Elapsed left zero, so the derived
+// rates read 0; ScenarioRun sets Elapsed once the run's duration is known.
+func (m *bandwidthMeter) snapshot() RateMetrics {
+	m.mu.Lock()
+	latencies := append([]time.Duration(nil), m.latencies...)
+	dns := append([]time.Duration(nil), m.dns...)
+	connect := append([]time.Duration(nil), m.connect...)
+	tlsTimes := append([]time.Duration(nil), m.tls...)
+	ttfb := append([]time.Duration(nil), m.ttfb...)
+	m.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return RateMetrics{
+		BytesSent:     atomic.LoadUint64(&m.bytesSent),
+		BytesReceived: atomic.LoadUint64(&m.bytesReceived),
+		RequestCount:  len(latencies),
+		P50:           percentile(latencies, 0.50),
+		P90:           percentile(latencies, 0.90),
+		P99:           percentile(latencies, 0.99),
+		P999:          percentile(latencies, 0.999),
+		AvgDNS:        average(dns),
+		AvgConnect:    average(connect),
+		AvgTLS:        average(tlsTimes),
+		AvgTTFB:       average(ttfb),
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func average(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+	}
+	return sum / time.Duration(len(samples))
+}
+
+// formatBytes renders a byte count as a human-readable IEC size, e.g.
+// "12.3MiB".
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	return fmt.Sprintf("%.1f%s", float64(b)/float64(div), units[exp])
+}
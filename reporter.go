@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ReportEvent is a single completed request, streamed to every ReportSink
+// while its scenario's run is still in progress.
+type ReportEvent struct {
+	Scenario      string
+	State         ResultState
+	Attempt       int
+	Latency       time.Duration
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// ReportSink consumes the live ReportEvent stream, e.g. to print it, persist
+// it to a file, or expose it over HTTP/UDP.
+type ReportSink interface {
+	Handle(ev ReportEvent)
+	Close() error
+}
+
+// reportRingSize is how many of the most recently emitted events Reporter
+// keeps buffered for RecentEvents, regardless of how many sinks are
+// attached.
+const reportRingSize = 1024
+
+// Reporter receives every ScenarioRun's ResultStates over a channel as they
+// happen and fans them out to a set of ReportSinks, so a run is observable
+// while in flight; the final summary is still built from the end-of-run
+// ScenarioReports that main collects. It also keeps the last reportRingSize events in a ring buffer for late
+// subscribers, e.g. a sink that only starts polling after the run begins.
+type Reporter struct {
+	sinks  []ReportSink
+	events chan ReportEvent
+	done   chan struct{}
+
+	mu     sync.Mutex
+	ring   []ReportEvent
+	cursor int
+}
+
+// NewReporter starts a Reporter fanning events out to sinks. Callers must
+// call Close once no more events will be emitted.
+func NewReporter(sinks ...ReportSink) *Reporter {
+	r := &Reporter{
+		sinks:  sinks,
+		events: make(chan ReportEvent, 256),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Reporter) run() {
+	defer close(r.done)
+	for ev := range r.events {
+		r.mu.Lock()
+		if len(r.ring) < reportRingSize {
+			r.ring = append(r.ring, ev)
+		} else {
+			r.ring[r.cursor] = ev
+			r.cursor = (r.cursor + 1) % reportRingSize
+		}
+		r.mu.Unlock()
+
+		for _, sink := range r.sinks {
+			sink.Handle(ev)
+		}
+	}
+}
+
+// Emit streams ev to every sink and into the ring buffer. It is safe to call
+// from multiple scenario goroutines concurrently.
+func (r *Reporter) Emit(ev ReportEvent) {
+	r.events <- ev
+}
+
+// RecentEvents returns up to reportRingSize of the most recently emitted
+// events, oldest first.
+func (r *Reporter) RecentEvents() []ReportEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.ring) < reportRingSize {
+		out := make([]ReportEvent, len(r.ring))
+		copy(out, r.ring)
+		return out
+	}
+
+	out := make([]ReportEvent, reportRingSize)
+	n := copy(out, r.ring[r.cursor:])
+	copy(out[n:], r.ring[:r.cursor])
+	return out
+}
+
+// Close stops accepting events, waits for the in-flight ones to drain, and
+// closes every sink.
+func (r *Reporter) Close() error {
+	close(r.events)
+	<-r.done
+
+	var firstErr error
+	for _, sink := range r.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// engine is the default request engine, overridable per-scenario via
+// Scenario.Engine and globally via the --engine flag.
+var engine = "net_http"
+
+// Response is the engine-agnostic result of a single scenario request.
+type Response struct {
+	StatusCode int
+	Latency    time.Duration
+}
+
+// Requester executes a single scenario request against a configured
+// backend. httpRequester (net/http) and fasthttpRequester (fasthttp) are
+// the two built-in implementations.
+type Requester interface {
+	Do(ctx context.Context, s Scenario) (Response, error)
+}
+
+// resolveEngine returns the engine to use for s: its own Engine field if
+// set, otherwise the global --engine flag.
+func resolveEngine(s Scenario) string {
+	if s.Engine != "" {
+		return s.Engine
+	}
+	return engine
+}
+
+// newRequester builds the Requester backend for s, wired up to record
+// bandwidth/latency against meter.
+func newRequester(s Scenario, meter *bandwidthMeter) (Requester, error) {
+	switch e := resolveEngine(s); e {
+	case "", "net_http":
+		return &httpRequester{th: newTransportHolder(meter)}, nil
+	case "fasthttp":
+		if simulateFailures && s.Chaos != nil {
+			return nil, xerrors.Errorf("%s: chaos injection is not supported by the fasthttp engine", s.Name)
+		}
+		return newFasthttpRequester(meter), nil
+	default:
+		return nil, xerrors.Errorf("unknown engine %q", e)
+	}
+}